@@ -82,7 +82,7 @@ type FixedFile struct {
 	Code         string               // Code after applying fixes.
 	Modified     bool                 // Whether the file was modified by this tool.
 	Generated    bool                 // Whether the file is a generated file.
-	Stats        []*spb.Entry         // List of proto accesses in Code (i.e. after applying rewrites).
+	Stats        []*spb.Entry         // List of proto accesses in Code. Only set for None unless ConfiguredPackage.StatsAfterRewrites.
 	Drifted      bool                 // Whether the file has drifted between CBT and HEAD.
 	RedFixes     map[unsafeReason]int // Number of fixes per unsafe category.
 }
@@ -225,6 +225,9 @@ type ConfiguredPackage struct {
 	ShowWork         bool
 	Testonly         bool
 	UseBuilders      BuilderUseType
+	// StatsAfterRewrites enables FixedFile.Stats for all levels, not just
+	// for None.
+	StatsAfterRewrites bool
 }
 
 // Fix fixes a Go package.
@@ -300,6 +303,7 @@ func (cpkg *ConfiguredPackage) Fix() (Result, error) {
 			testonly:                         cpkg.Testonly,
 			helperVariableNames:              make(map[string]bool),
 			numUnsafeRewritesByReason:        map[unsafeReason]int{},
+			statsAfterRewrites:               cpkg.StatsAfterRewrites,
 		}
 		knownNoType := exprsWithNoType(c, dstFile)
 		out[None] = append(out[None], &FixedFile{
@@ -84,6 +84,9 @@ type cursor struct {
 	helperVariableNames map[string]bool
 
 	numUnsafeRewritesByReason map[unsafeReason]int
+
+	// statsAfterRewrites enables stats for the rewritten code of all levels.
+	statsAfterRewrites bool
 }
 
 func (c *cursor) Logf(format string, a ...any) {
@@ -136,6 +139,11 @@ const (
 	// MaybeNilPointerDeref means the rewrite might produce code that leads
 	// to nil pointer references that were not in the code before.
 	MaybeNilPointerDeref
+	// NilVersusEmpty means the code distinguishes nil from empty (but
+	// non-nil) repeated, map or bytes fields. Protobuf does not preserve
+	// that difference (e.g. when marshaling) and the rewritten code might
+	// behave differently. A comment is left in the code.
+	NilVersusEmpty
 )
 
 func (c *cursor) ReplaceUnsafe(n dst.Node, rt unsafeReason) {
@@ -14,11 +14,11 @@ import (
 
 // hasPre rewrites comparisons with nil in the following cases:
 //   - for proto2 optional scalar fields, replace with "!m.HasF()" or "m.HasF()"
-//   - for proto3 bytes fields, replace with "len(m.GetF()) == 0" or "len(m.GetF()) > 0"
 //   - for simple conditionals (e.g. "if f := m.F; f != nil {") replace with "if m.HasF()"
 //
-// The function does not rewrite proto3 message fields, map fields, or repeated
+// The function does not rewrite proto3 bytes fields, map fields, or repeated
 // fields. Those are handled by changing the direct field access to a Get call.
+// Their comparisons with nil are flagged by nilEmptyPre.
 //
 // This function is executed by traversing the tree in preorder.
 func hasPre(c *cursor) bool {
@@ -126,45 +126,8 @@ func hasPre(c *cursor) bool {
 		}
 		return false
 	}
-	field, ok := c.trackedProtoFieldSelector(expr.X)
-	if !ok {
-		return true
-	}
-	if s, ok := types.Unalias(c.typeOf(field)).(*types.Slice); ok {
-		// use "len" for proto3 bytes fields.
-		if bt, ok := types.Unalias(s.Elem()).(*types.Basic); ok && bt.Kind() == types.Byte {
-			// m.F == nil   => len(m.GetF()) == 0
-			// m.F != nil   => len(m.GetF()) != 0
-			var getVal dst.Expr = field
-			if isPtr(c.typeOf(field.X)) || c.canAddr(field.X) {
-				getVal = sel2call(c, "Get", field, nil, dst.NodeDecs{})
-			}
-			lenCall := &dst.CallExpr{
-				Fun:  dst.NewIdent("len"),
-				Args: []dst.Expr{getVal},
-			}
-			c.setType(lenCall, types.Typ[types.Int])
-			c.setType(lenCall.Fun, types.Universe.Lookup("len").Type())
-			op := token.EQL
-			if expr.Op == token.NEQ {
-				op = token.NEQ
-			}
-			zero := &dst.BasicLit{Kind: token.INT, Value: "0"}
-			c.setType(zero, types.Typ[types.Int])
-			bop := &dst.BinaryExpr{
-				X:    lenCall,
-				Op:   op,
-				Y:    zero,
-				Decs: expr.Decs,
-			}
-			c.setType(bop, types.Typ[types.Bool])
-			c.Replace(bop)
-			return true
-		}
-	}
-
-	// We don't handle repeated fields and maps explicitly here. We handle those
-	// cases by rewriting the code to use Get calls:
+	// We don't handle repeated fields, maps and proto3 bytes fields explicitly
+	// here. We handle those cases by rewriting the code to use Get calls:
 	//
 	//   m.F == nil   => m.GetF() == nil
 	//   m.F != nil   => m.GetF() != nil
@@ -177,12 +140,14 @@ func hasPre(c *cursor) bool {
 	//
 	//   m.GetF() == nil
 	//
-	// This works and preserves the old API behavior. However, it's
-	// a discouraged pattern in new code. It's better to check the
-	// length instead.
+	// This works and preserves the old API behavior for repeated fields and
+	// maps, but not for bytes fields: their setters store an empty slice for
+	// nil. Either way, it's a discouraged pattern in new code. It's better to
+	// check the length instead.
 	//
 	// We DO NOT do that as it couldn't be a green rewrite due to
-	// the difference between nil and zero-length slices.
+	// the difference between nil and zero-length slices. See nilEmptyPre
+	// for the cases where it is safe and for flagging the others.
 
 	return true
 }
@@ -539,7 +539,7 @@ _ = m3.Map != nil
 `,
 		want: map[Level]string{
 			Green: `
-_ = len(m3.GetBytes()) != 0
+_ = m3.GetBytes() != nil
 _ = m3.HasM()
 _ = m3.GetIs() != nil
 _ = m3.GetMs() != nil
@@ -555,7 +555,7 @@ _ = m3.Map == nil
 `,
 		want: map[Level]string{
 			Green: `
-_ = len(m3.GetBytes()) == 0
+_ = m3.GetBytes() == nil
 _ = !m3.HasM()
 _ = m3.GetIs() == nil
 _ = m3.GetMs() == nil
@@ -573,7 +573,7 @@ _ = m3val.Map != nil
 		want: map[Level]string{
 			Green: `
 var m3val pb3.M3
-_ = len(m3val.GetBytes()) != 0
+_ = m3val.GetBytes() != nil
 _ = m3val.HasM()
 _ = m3val.GetIs() != nil
 _ = m3val.GetMs() != nil
@@ -591,7 +591,7 @@ _ = m3val.Map == nil
 		want: map[Level]string{
 			Green: `
 var m3val pb3.M3
-_ = len(m3val.GetBytes()) == 0
+_ = m3val.GetBytes() == nil
 _ = !m3val.HasM()
 _ = m3val.GetIs() == nil
 _ = m3val.GetMs() == nil
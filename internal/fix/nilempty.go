@@ -0,0 +1,503 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fix
+
+import (
+	"go/constant"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/dave/dst"
+	"github.com/dave/dst/dstutil"
+)
+
+const (
+	nilComparisonComment = "// DO NOT SUBMIT: Protobuf does not distinguish nil from empty repeated, map and bytes fields (e.g. after marshaling): compare len(...) with 0 unless nil is meaningful here."
+	emptyStoreComment    = "// DO NOT SUBMIT: Protobuf does not distinguish nil from empty repeated, map and bytes fields (e.g. after marshaling): store nil unless the empty value is meaningful here."
+	nilBytesStoreComment = "// DO NOT SUBMIT: The Opaque API stores an empty, non-nil slice when setting a bytes field to nil: check for unset fields with len(...) == 0, not with nil comparisons."
+)
+
+// nilEmptyPre handles code that distinguishes nil from empty (but non-nil)
+// repeated, map and bytes fields. Protobuf does not preserve that difference:
+// it is lost when marshaling and the Opaque API bytes setters store empty
+// slices for nil.
+//
+// Where the semantics are provably the same, it rewrites the code to use len:
+//
+//	m.F == nil || len(m.F) == 0   =>   len(m.F) == 0
+//	m.F != nil && len(m.F) > 0    =>   len(m.F) > 0
+//
+//	if m.F != nil {               =>   if len(m.F) != 0 {
+//	  for _, v := range m.F {            for _, v := range m.F {
+//
+// Comparisons of bytes fields with explicit presence are rewritten to Has
+// calls by hasPre, and lazy initialization (e.g. "if m.F == nil { m.F = ... }")
+// does not depend on the difference. All other comparisons with nil, stores of
+// empty slices and stores of nil in bytes fields without explicit presence
+// (with assignments, setters, composite literals or builders) are flagged as
+// NilVersusEmpty at the red level. The direct field accesses themselves are
+// handled by other rewrites.
+//
+// This function is executed by traversing the tree in preorder.
+func nilEmptyPre(c *cursor) bool {
+	switch n := c.Node().(type) {
+	case *dst.BinaryExpr:
+		if x, ok := c.redundantNilCheck(n); ok {
+			c.Replace(x)
+		}
+
+	case *dst.IfStmt:
+		if c.rewriteRangeGuard(n) {
+			c.Replace(n)
+		}
+	}
+
+	// Flag whole statements rather than single expressions so that the
+	// comments end up above the closest statement and so that statements
+	// with multiple flagged expressions are counted once per comment.
+	switch n := c.Node().(type) {
+	case dst.Stmt:
+		c.flagNilVersusEmpty(n)
+	case *dst.GenDecl:
+		if _, ok := c.Parent().(*dst.File); ok {
+			c.flagNilVersusEmpty(n)
+		}
+	}
+	return true
+}
+
+// rewriteRangeGuard rewrites the comparison with nil in n to a len comparison
+// if n only guards a range loop over the same field:
+//
+//	if m.F != nil {   =>   if len(m.F) != 0 {
+//	  for ... range m.F {
+func (c *cursor) rewriteRangeGuard(n *dst.IfStmt) bool {
+	if n.Init != nil || n.Else != nil || len(n.Body.List) != 1 {
+		return false
+	}
+	rng, ok := n.Body.List[0].(*dst.RangeStmt)
+	if !ok {
+		return false
+	}
+	x, op, ok := nilComparison(n.Cond)
+	if !ok || op != token.NEQ {
+		return false
+	}
+	if !c.sameNilEmptyOperand(x, rng.X) {
+		return false
+	}
+	n.Cond = lenComparison(c, dstutil.Unparen(x), token.NEQ, n.Cond.(*dst.BinaryExpr).Decs)
+	return true
+}
+
+// flagNilVersusEmpty adds comments above stmt, a statement or a declaration,
+// at the red level if its expressions rely on the difference between nil and
+// empty fields. Nested statements are flagged separately. Each added comment
+// is counted as NilVersusEmpty.
+func (c *cursor) flagNilVersusEmpty(stmt dst.Node) {
+	if !c.lvl.ge(Red) {
+		return
+	}
+	flag := func(x dst.Expr, comment string) {
+		if addCommentAbove(stmt, x, comment) {
+			c.numUnsafeRewritesByReason[NilVersusEmpty]++
+		}
+	}
+	dst.Inspect(stmt, func(n dst.Node) bool {
+		if _, ok := n.(dst.Stmt); ok && n != stmt {
+			return false
+		}
+		switch n := n.(type) {
+		case *dst.BinaryExpr:
+			if _, ok := c.redundantNilCheck(n); ok {
+				// nilEmptyPre simplifies n when it visits it.
+				return false
+			}
+			x, op, ok := nilComparison(n)
+			if !ok {
+				return true
+			}
+			o, ok := c.nilEmptyOperand(x)
+			if !ok || o.explicitPresence {
+				return true
+			}
+			if ifstmt, ok := stmt.(*dst.IfStmt); ok && ifstmt.Cond == n && op == token.EQL && c.initializes(ifstmt.Body, o) {
+				return true
+			}
+			flag(n, nilComparisonComment)
+
+		case *dst.AssignStmt:
+			// m.F = []T{}
+			if n.Tok != token.ASSIGN || len(n.Lhs) != len(n.Rhs) {
+				return true
+			}
+			for i, lhs := range n.Lhs {
+				field, ok := c.trackedProtoFieldSelector(lhs)
+				if !ok {
+					continue
+				}
+				if comment, ok := c.nilEmptyStoreComment(c.typeOf(field.X), field.Sel.Name, c.typeOf(field), n.Rhs[i]); ok {
+					flag(n.Rhs[i], comment)
+				}
+			}
+
+		case *dst.CallExpr:
+			// m.SetF([]T{})
+			sel, ok := n.Fun.(*dst.SelectorExpr)
+			if !ok || len(n.Args) != 1 || !strings.HasPrefix(sel.Sel.Name, "Set") {
+				return true
+			}
+			if t := c.typeOfOrNil(sel.X); t == nil || !c.shouldUpdateType(t) {
+				return true
+			}
+			sig, ok := types.Unalias(c.underlyingTypeOf(sel)).(*types.Signature)
+			if !ok || sig.Params().Len() != 1 {
+				return true
+			}
+			arg := n.Args[0]
+			comment, ok := c.nilEmptyStoreComment(c.typeOf(sel.X), strings.TrimPrefix(sel.Sel.Name, "Set"), sig.Params().At(0).Type(), arg)
+			if !ok {
+				return true
+			}
+			// Setters created by other rewrites carry the decorations (e.g.
+			// empty lines) of the original statement. Move them to the
+			// statement so that the comment is placed directly above it.
+			if es, ok := stmt.(*dst.ExprStmt); ok && es.X == n && len(es.Decs.Start) == 0 {
+				es.Decs.Before, n.Decs.Before = n.Decs.Before, dst.None
+				es.Decs.Start, n.Decs.Start = n.Decs.Start, nil
+			}
+			flag(arg, comment)
+
+		case *dst.CompositeLit:
+			// &pb.M{F: []T{}} and pb.M_builder{F: []T{}}.Build()
+			msg, ok := c.literalMessageType(n)
+			if !ok {
+				return true
+			}
+			// Builder types created by other rewrites have no fields, so look
+			// up the field types in the message.
+			if p, ok := msg.Underlying().(*types.Pointer); ok {
+				msg = p.Elem()
+			}
+			st, ok := msg.Underlying().(*types.Struct)
+			if !ok {
+				return true
+			}
+			for _, e := range n.Elts {
+				kv, ok := e.(*dst.KeyValueExpr)
+				if !ok {
+					continue
+				}
+				key, ok := kv.Key.(*dst.Ident)
+				if !ok {
+					continue
+				}
+				for i := 0; i < st.NumFields(); i++ {
+					if f := st.Field(i); f.Name() == key.Name {
+						if comment, ok := c.nilEmptyStoreComment(msg, key.Name, f.Type(), kv.Value); ok {
+							flag(kv.Value, comment)
+						}
+					}
+				}
+			}
+		}
+		return true
+	})
+}
+
+// literalMessageType returns the type of the proto message that lit creates,
+// if lit is a literal of a proto message or of its builder.
+func (c *cursor) literalMessageType(lit *dst.CompositeLit) (types.Type, bool) {
+	t := c.typeOfOrNil(lit)
+	if t == nil {
+		return nil, false
+	}
+	if c.isBuilderType(t) {
+		build, _, _ := types.LookupFieldOrMethod(t, true, nil, "Build")
+		t = build.Type().(*types.Signature).Results().At(0).Type()
+	}
+	return t, c.shouldUpdateType(t)
+}
+
+// nilEmptyOperand is an access to a repeated, map or bytes field of a proto
+// message: either a direct field access ("m.F") or a Get call ("m.GetF()").
+type nilEmptyOperand struct {
+	recv dst.Expr // The message, i.e. "m".
+	name string   // The field name used by the accessors, i.e. "F".
+	// explicitPresence is set for bytes fields with explicit presence. Their
+	// comparisons with nil are equivalent to Has calls.
+	explicitPresence bool
+}
+
+// nilEmptyOperand returns the field access x if x is a direct field access or
+// a Get call of a repeated, map or bytes field.
+func (c *cursor) nilEmptyOperand(x dst.Expr) (nilEmptyOperand, bool) {
+	var (
+		recv dst.Expr
+		name string
+		t    types.Type
+	)
+	switch x := dstutil.Unparen(x).(type) {
+	case *dst.SelectorExpr:
+		field, ok := c.trackedProtoFieldSelector(x)
+		if !ok {
+			return nilEmptyOperand{}, false
+		}
+		recv, name, t = field.X, field.Sel.Name, c.typeOf(field)
+	case *dst.CallExpr:
+		sel, ok := x.Fun.(*dst.SelectorExpr)
+		if !ok || len(x.Args) != 0 || !strings.HasPrefix(sel.Sel.Name, "Get") {
+			return nilEmptyOperand{}, false
+		}
+		if rt := c.typeOfOrNil(sel.X); rt == nil || !c.shouldUpdateType(rt) {
+			return nilEmptyOperand{}, false
+		}
+		recv, name, t = sel.X, strings.TrimPrefix(sel.Sel.Name, "Get"), c.typeOf(x)
+	default:
+		return nilEmptyOperand{}, false
+	}
+	switch t.Underlying().(type) {
+	case *types.Slice, *types.Map:
+	default:
+		return nilEmptyOperand{}, false
+	}
+	return nilEmptyOperand{
+		recv:             recv,
+		name:             name,
+		explicitPresence: isBytes(t) && fieldHasExplicitPresence(c.typeOf(recv), name),
+	}, true
+}
+
+// sameNilEmptyOperand reports whether x and y access the same repeated, map or
+// bytes field, either directly or with a Get call. The message expression must
+// be free of side effects.
+func (c *cursor) sameNilEmptyOperand(x, y dst.Expr) bool {
+	ox, ok := c.nilEmptyOperand(x)
+	if !ok {
+		return false
+	}
+	oy, ok := c.nilEmptyOperand(y)
+	if !ok {
+		return false
+	}
+	return ox.name == oy.name && c.isSideEffectFree(ox.recv) && sameExpr(c, ox.recv, oy.recv)
+}
+
+// redundantNilCheck returns the len comparison of x if x combines it with a
+// comparison of the same field with nil that does not change the result:
+//
+//	m.F == nil || len(m.F) == 0
+//	m.F != nil && len(m.F) > 0
+//
+// The operands can be in any order.
+func (c *cursor) redundantNilCheck(x *dst.BinaryExpr) (dst.Expr, bool) {
+	var want token.Token
+	switch x.Op {
+	case token.LOR:
+		want = token.EQL
+	case token.LAND:
+		want = token.NEQ
+	default:
+		return nil, false
+	}
+	for _, pair := range [][2]dst.Expr{{x.X, x.Y}, {x.Y, x.X}} {
+		nilCmp, lenCmp := dstutil.Unparen(pair[0]), dstutil.Unparen(pair[1])
+		field, op, ok := nilComparison(nilCmp)
+		if !ok || op != want {
+			continue
+		}
+		lenArg, lenOp, ok := c.lenComparisonWithZero(lenCmp)
+		if !ok || lenOp != want {
+			continue
+		}
+		if !c.sameNilEmptyOperand(field, lenArg) {
+			continue
+		}
+		return lenCmp, true
+	}
+	return nil, false
+}
+
+// initializes reports whether the first statement of b stores a value in the
+// field o, as in the lazy initialization "if m.F == nil { m.F = ... }".
+func (c *cursor) initializes(b *dst.BlockStmt, o nilEmptyOperand) bool {
+	if len(b.List) == 0 {
+		return false
+	}
+	switch stmt := b.List[0].(type) {
+	case *dst.AssignStmt:
+		if stmt.Tok != token.ASSIGN || len(stmt.Lhs) != 1 {
+			return false
+		}
+		lhs, ok := c.nilEmptyOperand(stmt.Lhs[0])
+		return ok && lhs.name == o.name && sameExpr(c, lhs.recv, o.recv)
+	case *dst.ExprStmt:
+		call, ok := stmt.X.(*dst.CallExpr)
+		if !ok {
+			return false
+		}
+		sel, ok := call.Fun.(*dst.SelectorExpr)
+		return ok && sel.Sel.Name == "Set"+o.name && sameExpr(c, sel.X, o.recv)
+	}
+	return false
+}
+
+// nilEmptyStoreComment returns the comment for storing x in the field name of
+// type t of the message type msg if the store relies on the difference
+// between nil and empty fields:
+//
+//   - Storing an empty slice (e.g. "[]T{}" or "make([]T, 0)") in a repeated
+//     or bytes field only makes a difference if the code distinguishes nil
+//     from empty fields.
+//   - Storing nil in a bytes field without explicit presence stores an empty
+//     slice in the Opaque API, so the field no longer compares equal to nil.
+//
+// Bytes fields with explicit presence are excluded as their nil-ness is
+// tracked by Has and Clear. Maps are excluded as well: unlike slices, which
+// can be appended to when nil, maps must be non-nil before storing entries.
+func (c *cursor) nilEmptyStoreComment(msg types.Type, name string, t types.Type, x dst.Expr) (string, bool) {
+	if _, ok := t.Underlying().(*types.Slice); !ok {
+		return "", false
+	}
+	if isBytes(t) && fieldHasExplicitPresence(msg, name) {
+		return "", false
+	}
+	if c.isEmptySlice(x) {
+		return emptyStoreComment, true
+	}
+	if ident, ok := dstutil.Unparen(x).(*dst.Ident); ok && ident.Name == "nil" && isBytes(t) {
+		return nilBytesStoreComment, true
+	}
+	return "", false
+}
+
+// isEmptySlice reports whether x is an empty, non-nil slice: an empty slice
+// literal ("[]T{}"), a make call with zero length ("make([]T, 0)" or
+// "make([]T, 0, n)") or a conversion of an empty string to bytes
+// ("[]byte("")").
+func (c *cursor) isEmptySlice(x dst.Expr) bool {
+	switch x := dstutil.Unparen(x).(type) {
+	case *dst.CompositeLit:
+		if len(x.Elts) != 0 {
+			return false
+		}
+		_, ok := c.underlyingTypeOf(x).(*types.Slice)
+		return ok
+	case *dst.CallExpr:
+		if isBytesConversion(c, x) {
+			return len(x.Args) == 1 && c.isConstant(x.Args[0], constant.MakeString(""))
+		}
+		fun, ok := x.Fun.(*dst.Ident)
+		if !ok || c.objectOf(fun) != types.Universe.Lookup("make") || len(x.Args) < 2 {
+			return false
+		}
+		if _, ok := c.underlyingTypeOf(x).(*types.Slice); !ok {
+			return false
+		}
+		return c.isConstant(x.Args[1], constant.MakeInt64(0))
+	}
+	return false
+}
+
+// isConstant reports whether x is a constant expression with the value v.
+func (c *cursor) isConstant(x dst.Expr, v constant.Value) bool {
+	tv, ok := c.typeAndValueOf(x)
+	if !ok || tv.Value == nil || tv.Value.Kind() != v.Kind() {
+		return false
+	}
+	return constant.Compare(tv.Value, token.EQL, v)
+}
+
+// nilComparison is like comparisonWithNil, but it also permits nil on the
+// left-hand side. It returns the expression that is compared with nil.
+func nilComparison(n dst.Node) (x dst.Expr, op token.Token, ok bool) {
+	if x, op, ok := comparisonWithNil(n); ok {
+		return x, op, true
+	}
+	b, ok := n.(*dst.BinaryExpr)
+	if !ok || (b.Op != token.EQL && b.Op != token.NEQ) {
+		return nil, 0, false
+	}
+	if ident, ok := b.X.(*dst.Ident); !ok || ident.Name != "nil" {
+		return nil, 0, false
+	}
+	return b.Y, b.Op, true
+}
+
+// lenComparisonWithZero checks that x is one of "len(y) == 0", "len(y) != 0"
+// or "len(y) > 0". If so, it returns y and the operator, where ">" is reported
+// as "!=".
+func (c *cursor) lenComparisonWithZero(x dst.Expr) (y dst.Expr, op token.Token, ok bool) {
+	b, ok := x.(*dst.BinaryExpr)
+	if !ok {
+		return nil, 0, false
+	}
+	switch b.Op {
+	case token.EQL, token.NEQ:
+		op = b.Op
+	case token.GTR:
+		op = token.NEQ
+	default:
+		return nil, 0, false
+	}
+	if zero, ok := b.Y.(*dst.BasicLit); !ok || zero.Kind != token.INT || zero.Value != "0" {
+		return nil, 0, false
+	}
+	call, ok := b.X.(*dst.CallExpr)
+	if !ok || len(call.Args) != 1 {
+		return nil, 0, false
+	}
+	if fun, ok := call.Fun.(*dst.Ident); !ok || c.objectOf(fun) != types.Universe.Lookup("len") {
+		return nil, 0, false
+	}
+	return call.Args[0], op, true
+}
+
+// lenComparison returns "len(x) OP 0".
+func lenComparison(c *cursor, x dst.Expr, op token.Token, decs dst.BinaryExprDecorations) *dst.BinaryExpr {
+	fun := dst.NewIdent("len")
+	c.setUse(fun, types.Universe.Lookup("len"))
+	lenCall := &dst.CallExpr{
+		Fun:  fun,
+		Args: []dst.Expr{x},
+	}
+	c.setType(lenCall, types.Typ[types.Int])
+	zero := &dst.BasicLit{Kind: token.INT, Value: "0"}
+	c.setType(zero, types.Typ[types.Int])
+	bop := &dst.BinaryExpr{
+		X:    lenCall,
+		Op:   op,
+		Y:    zero,
+		Decs: decs,
+	}
+	c.setType(bop, types.Typ[types.Bool])
+	return bop
+}
+
+// sameExpr reports whether x and y are structurally equal and refer to the
+// same objects. It only supports the expressions that isSideEffectFree
+// permits and returns false for everything else.
+func sameExpr(c *cursor, x, y dst.Expr) bool {
+	x, y = dstutil.Unparen(x), dstutil.Unparen(y)
+	switch x := x.(type) {
+	case *dst.Ident:
+		y, ok := y.(*dst.Ident)
+		return ok && x.Name == y.Name && c.objectOf(x) == c.objectOf(y)
+	case *dst.BasicLit:
+		y, ok := y.(*dst.BasicLit)
+		return ok && x.Kind == y.Kind && x.Value == y.Value
+	case *dst.SelectorExpr:
+		y, ok := y.(*dst.SelectorExpr)
+		return ok && x.Sel.Name == y.Sel.Name && sameExpr(c, x.X, y.X)
+	case *dst.IndexExpr:
+		y, ok := y.(*dst.IndexExpr)
+		return ok && sameExpr(c, x.X, y.X) && sameExpr(c, x.Index, y.Index)
+	case *dst.CallExpr:
+		y, ok := y.(*dst.CallExpr)
+		return ok && len(x.Args) == 0 && len(y.Args) == 0 && sameExpr(c, x.Fun, y.Fun)
+	}
+	return false
+}
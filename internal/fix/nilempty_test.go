@@ -0,0 +1,381 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fix
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/open2opaque/internal/o2o/fakeloader"
+	"google.golang.org/open2opaque/internal/o2o/loader"
+	"google.golang.org/open2opaque/internal/o2o/syncset"
+)
+
+func TestNilEmpty(t *testing.T) {
+	const (
+		cmpComment      = "// DO NOT SUBMIT: Protobuf does not distinguish nil from empty repeated, map and bytes fields (e.g. after marshaling): compare len(...) with 0 unless nil is meaningful here."
+		storeComment    = "// DO NOT SUBMIT: Protobuf does not distinguish nil from empty repeated, map and bytes fields (e.g. after marshaling): store nil unless the empty value is meaningful here."
+		nilBytesComment = "// DO NOT SUBMIT: The Opaque API stores an empty, non-nil slice when setting a bytes field to nil: check for unset fields with len(...) == 0, not with nil comparisons."
+	)
+
+	tests := []test{
+		{
+			desc: "redundant nil checks",
+			in: `
+_ = m2.Is == nil || len(m2.Is) == 0
+_ = len(m3.Map) == 0 || m3.Map == nil
+_ = m3.Bytes != nil && len(m3.Bytes) > 0
+_ = (nil != m2.Ms) && (len(m2.Ms) != 0)
+_ = m2.Bytes == nil || len(m2.Bytes) == 0
+_ = m2.GetIs() == nil || len(m2.GetIs()) == 0
+`,
+			want: map[Level]string{
+				Green: `
+_ = len(m2.GetIs()) == 0
+_ = len(m3.GetMap()) == 0
+_ = len(m3.GetBytes()) > 0
+_ = len(m2.GetMs()) != 0
+_ = len(m2.GetBytes()) == 0
+_ = len(m2.GetIs()) == 0
+`,
+				Red: `
+_ = len(m2.GetIs()) == 0
+_ = len(m3.GetMap()) == 0
+_ = len(m3.GetBytes()) > 0
+_ = len(m2.GetMs()) != 0
+_ = len(m2.GetBytes()) == 0
+_ = len(m2.GetIs()) == 0
+`,
+			},
+		},
+
+		{
+			desc: "nil checks of different fields",
+			in: `
+_ = m2.Is == nil || len(m3.Is) == 0
+_ = m2.Is != nil && len(m2.Is) == 0
+`,
+			want: map[Level]string{
+				Green: `
+_ = m2.GetIs() == nil || len(m3.GetIs()) == 0
+_ = m2.GetIs() != nil && len(m2.GetIs()) == 0
+`,
+				Red: `
+` + cmpComment + `
+_ = m2.GetIs() == nil || len(m3.GetIs()) == 0
+` + cmpComment + `
+_ = m2.GetIs() != nil && len(m2.GetIs()) == 0
+`,
+			},
+		},
+
+		{
+			desc: "nil check guarding range loop",
+			in: `
+if m2.Is != nil {
+	for _, i := range m2.Is {
+		_ = i
+	}
+}
+if m3.GetMap() != nil {
+	for k := range m3.Map {
+		_ = k
+	}
+}
+`,
+			want: map[Level]string{
+				Green: `
+if len(m2.GetIs()) != 0 {
+	for _, i := range m2.GetIs() {
+		_ = i
+	}
+}
+if len(m3.GetMap()) != 0 {
+	for k := range m3.GetMap() {
+		_ = k
+	}
+}
+`,
+				Red: `
+if len(m2.GetIs()) != 0 {
+	for _, i := range m2.GetIs() {
+		_ = i
+	}
+}
+if len(m3.GetMap()) != 0 {
+	for k := range m3.GetMap() {
+		_ = k
+	}
+}
+`,
+			},
+		},
+
+		{
+			desc: "nil check guarding more than a range loop",
+			in: `
+if m2.Is != nil {
+	for _, i := range m2.Is {
+		_ = i
+	}
+	_ = 1
+}
+`,
+			want: map[Level]string{
+				Green: `
+if m2.GetIs() != nil {
+	for _, i := range m2.GetIs() {
+		_ = i
+	}
+	_ = 1
+}
+`,
+				Red: `
+` + cmpComment + `
+if m2.GetIs() != nil {
+	for _, i := range m2.GetIs() {
+		_ = i
+	}
+	_ = 1
+}
+`,
+			},
+		},
+
+		{
+			desc: "lazy initialization",
+			in: `
+if m3.Map == nil {
+	m3.Map = map[string]bool{}
+}
+if m2.Ms == nil {
+	m2.Ms = []*pb2.M2{m2}
+}
+`,
+			want: map[Level]string{
+				Green: `
+if m3.GetMap() == nil {
+	m3.SetMap(map[string]bool{})
+}
+if m2.GetMs() == nil {
+	m2.SetMs([]*pb2.M2{m2})
+}
+`,
+				Red: `
+if m3.GetMap() == nil {
+	m3.SetMap(map[string]bool{})
+}
+if m2.GetMs() == nil {
+	m2.SetMs([]*pb2.M2{m2})
+}
+`,
+			},
+		},
+
+		{
+			desc: "comparisons with nil",
+			in: `
+_ = m2.Ms == nil
+_ = nil != m3.Is
+_ = m3.Bytes != nil
+_ = m3.GetBytes() != nil
+_ = m2.GetBytes() != nil
+_ = m2.Bytes == nil
+`,
+			want: map[Level]string{
+				Green: `
+_ = m2.GetMs() == nil
+_ = nil != m3.GetIs()
+_ = m3.GetBytes() != nil
+_ = m3.GetBytes() != nil
+_ = m2.GetBytes() != nil
+_ = !m2.HasBytes()
+`,
+				Red: `
+` + cmpComment + `
+_ = m2.GetMs() == nil
+` + cmpComment + `
+_ = nil != m3.GetIs()
+` + cmpComment + `
+_ = m3.GetBytes() != nil
+` + cmpComment + `
+_ = m3.GetBytes() != nil
+_ = m2.GetBytes() != nil
+_ = !m2.HasBytes()
+`,
+			},
+		},
+
+		{
+			desc: "stores of empty values",
+			in: `
+m2.Is = []int32{}
+m3.Bytes = []byte{}
+m3.SetMs([]*pb3.M3{})
+m2.Is = make([]int32, 0)
+m3.Ms = make([]*pb3.M3, 0, 10)
+m3.Bytes = []byte("")
+m2.Bytes = []byte{}
+m2.Bytes = []byte("")
+m3.Map = map[string]bool{}
+m2.Is = []int32{1}
+m2.Is = make([]int32, 1)
+m3.Bytes = []byte("b")
+`,
+			want: map[Level]string{
+				Green: `
+m2.SetIs([]int32{})
+m3.SetBytes([]byte{})
+m3.SetMs([]*pb3.M3{})
+m2.SetIs(make([]int32, 0))
+m3.SetMs(make([]*pb3.M3, 0, 10))
+m3.SetBytes([]byte(""))
+m2.SetBytes([]byte{})
+m2.SetBytes([]byte(""))
+m3.SetMap(map[string]bool{})
+m2.SetIs([]int32{1})
+m2.SetIs(make([]int32, 1))
+m3.SetBytes([]byte("b"))
+`,
+				Red: `
+` + storeComment + `
+m2.SetIs([]int32{})
+` + storeComment + `
+m3.SetBytes([]byte{})
+` + storeComment + `
+m3.SetMs([]*pb3.M3{})
+` + storeComment + `
+m2.SetIs(make([]int32, 0))
+` + storeComment + `
+m3.SetMs(make([]*pb3.M3, 0, 10))
+` + storeComment + `
+m3.SetBytes([]byte(""))
+m2.SetBytes([]byte{})
+m2.SetBytes([]byte(""))
+m3.SetMap(map[string]bool{})
+m2.SetIs([]int32{1})
+m2.SetIs(make([]int32, 1))
+m3.SetBytes([]byte("b"))
+`,
+			},
+		},
+
+		{
+			desc: "stores of nil",
+			in: `
+m3.Bytes = nil
+m3.SetBytes(nil)
+m2.Bytes = nil
+m3.Is = nil
+m3.Map = nil
+`,
+			want: map[Level]string{
+				Green: `
+m3.SetBytes(nil)
+m3.SetBytes(nil)
+m2.ClearBytes()
+m3.SetIs(nil)
+m3.SetMap(nil)
+`,
+				Red: `
+` + nilBytesComment + `
+m3.SetBytes(nil)
+` + nilBytesComment + `
+m3.SetBytes(nil)
+m2.ClearBytes()
+m3.SetIs(nil)
+m3.SetMap(nil)
+`,
+			},
+		},
+
+		{
+			desc: "stores in composite literals",
+			in: `
+_ = &pb3.M3{Is: []int32{}, Bytes: []byte{}}
+_ = &pb3.M3{Bytes: nil}
+_ = &pb3.M3{Ms: make([]*pb3.M3, 0)}
+_ = &pb2.M2{Bytes: nil, Is: []int32{1}}
+_ = []*pb3.M3{{Bytes: []byte("")}}
+`,
+			want: map[Level]string{
+				Green: `
+_ = pb3.M3_builder{Is: []int32{}, Bytes: []byte{}}.Build()
+_ = pb3.M3_builder{Bytes: nil}.Build()
+_ = pb3.M3_builder{Ms: make([]*pb3.M3, 0)}.Build()
+_ = pb2.M2_builder{Bytes: nil, Is: []int32{1}}.Build()
+_ = []*pb3.M3{pb3.M3_builder{Bytes: []byte("")}.Build()}
+`,
+				Red: `
+` + storeComment + `
+_ = pb3.M3_builder{Is: []int32{}, Bytes: []byte{}}.Build()
+` + nilBytesComment + `
+_ = pb3.M3_builder{Bytes: nil}.Build()
+` + storeComment + `
+_ = pb3.M3_builder{Ms: make([]*pb3.M3, 0)}.Build()
+_ = pb2.M2_builder{Bytes: nil, Is: []int32{1}}.Build()
+` + storeComment + `
+_ = []*pb3.M3{pb3.M3_builder{Bytes: []byte("")}.Build()}
+`,
+			},
+		},
+	}
+
+	runTableTests(t, tests)
+}
+
+func TestNilEmptyRedFixes(t *testing.T) {
+	tests := []struct {
+		desc string
+		in   string
+		want int
+	}{
+		{
+			desc: "one comment per statement",
+			in:   `_ = m3.GetIs() == nil && m3.GetBytes() == nil`,
+			want: 1,
+		},
+		{
+			desc: "one comment per composite literal",
+			in:   `_ = &pb3.M3{Is: []int32{}, Bytes: []byte{}}`,
+			want: 1,
+		},
+		{
+			desc: "separate statements",
+			in: `_ = m3.GetIs() == nil
+_ = m3.GetBytes() == nil
+m3.Bytes = nil`,
+			want: 3,
+		},
+	}
+
+	const ruleName = "google.golang.org/open2opaque/internal/fix/testdata/fake"
+	const srcfile = ruleName + "/pkg_test.go"
+	for _, tt := range tests {
+		t.Run(tt.desc, func(t *testing.T) {
+			flBase.ImportPathToFiles[ruleName] = []string{srcfile}
+			flBase.PathToContent[srcfile] = NewSrc(tt.in, "")
+			l := fakeloader.NewFakeLoader(flBase.ImportPathToFiles, flBase.PathToContent, nil, flBase.ExportFor)
+			pkg, err := loader.LoadOne(context.Background(), l, &loader.Target{ID: ruleName})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cpkg := ConfiguredPackage{
+				Loader:         l,
+				Pkg:            pkg,
+				Levels:         []Level{Green, Yellow, Red},
+				ProcessedFiles: syncset.New(),
+				UseBuilders:    BuildersTestsOnly,
+			}
+			res, err := cpkg.Fix()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := res[Red][0].RedFixes[NilVersusEmpty]; got != tt.want {
+				t.Errorf("RedFixes[NilVersusEmpty] = %d, want %d; code:\n%s", got, tt.want, res[Red][0].Code)
+			}
+		})
+	}
+}
@@ -24,6 +24,12 @@ func init() {
 		{name: "usePointersPre", pre: usePointersPre},
 		// incdec.go
 		{name: "incDecPre", pre: incDecPre},
+		// The nilEmptyPre stage needs to run before hasPre because it
+		// simplifies comparisons with nil that hasPre would otherwise rewrite
+		// one by one.
+		//
+		// nilempty.go
+		{name: "nilEmptyPre", pre: nilEmptyPre},
 		// The hasPre stage needs to run before convertToSetterPost because it
 		// generates direct fields accesses on the lhs of assignments which
 		// convertToSetterPost rewrites to setters.
@@ -94,8 +100,9 @@ func isStatementOrDeclaration(n dst.Node) bool {
 
 // addCommentAbove locates the specified expression (can be part of a line),
 // walks up to the parent nodes until it encounters a statement or declaration
-// (full line) and adds the specified comment.
-func addCommentAbove(root dst.Node, expr dst.Expr, comment string) {
+// (full line) and adds the specified comment. It reports whether the comment
+// was added, i.e. whether the statement or declaration did not have it yet.
+func addCommentAbove(root dst.Node, expr dst.Expr, comment string) (added bool) {
 	// Pre-allocate to avoid memory allocations up until 100 levels of nesting.
 	stack := make([]dst.Node, 0, 100)
 
@@ -117,6 +124,7 @@ func addCommentAbove(root dst.Node, expr dst.Expr, comment string) {
 					}
 					decs.Start = append(decs.Start, comment)
 					decs.Before = dst.NewLine
+					added = true
 					break
 				}
 				verdict = false // stop recursing
@@ -128,6 +136,7 @@ func addCommentAbove(root dst.Node, expr dst.Expr, comment string) {
 			stack = stack[:len(stack)-1] // pop
 			return true
 		})
+	return added
 }
 
 // scalarTypeZeroExpr returns an expression for zero value of the given type
@@ -842,8 +842,11 @@ _ = func(msg *pb2.M2) *uint32 { return proto.ValueOrNil(msg.HasUi32(), msg.GetUi
 _ = func(msg *pb2.M2) *uint64 { return proto.ValueOrNil(msg.HasUi64(), msg.GetUi64) }(f2()) != nil
 _ = func(msg *pb2.M2) *string { return proto.ValueOrNil(msg.HasS(), msg.GetS) }(f2()) != nil
 _ = f2().HasM()
+// DO NOT SUBMIT: Protobuf does not distinguish nil from empty repeated, map and bytes fields (e.g. after marshaling): compare len(...) with 0 unless nil is meaningful here.
 _ = f2().GetIs() != nil
+// DO NOT SUBMIT: Protobuf does not distinguish nil from empty repeated, map and bytes fields (e.g. after marshaling): compare len(...) with 0 unless nil is meaningful here.
 _ = f2().GetMs() != nil
+// DO NOT SUBMIT: Protobuf does not distinguish nil from empty repeated, map and bytes fields (e.g. after marshaling): compare len(...) with 0 unless nil is meaningful here.
 _ = f2().GetMap() != nil
 _ = func(msg *pb2.M2) *pb2.M2_Enum { return proto.ValueOrNil(msg.HasE(), msg.GetE) }(f2()) != nil
 
@@ -893,7 +896,7 @@ _ = f3().Is
 _ = f3().Ms
 _ = f3().Map
 
-_ = len(f3().Bytes) != 0
+_ = f3().Bytes != nil
 _ = f3().M != nil
 _ = f3().Is != nil
 _ = f3().Ms != nil
@@ -914,10 +917,14 @@ _ = f3().GetIs()
 _ = f3().GetMs()
 _ = f3().GetMap()
 
-_ = len(f3().GetBytes()) != 0
+// DO NOT SUBMIT: Protobuf does not distinguish nil from empty repeated, map and bytes fields (e.g. after marshaling): compare len(...) with 0 unless nil is meaningful here.
+_ = f3().GetBytes() != nil
 _ = f3().HasM()
+// DO NOT SUBMIT: Protobuf does not distinguish nil from empty repeated, map and bytes fields (e.g. after marshaling): compare len(...) with 0 unless nil is meaningful here.
 _ = f3().GetIs() != nil
+// DO NOT SUBMIT: Protobuf does not distinguish nil from empty repeated, map and bytes fields (e.g. after marshaling): compare len(...) with 0 unless nil is meaningful here.
 _ = f3().GetMs() != nil
+// DO NOT SUBMIT: Protobuf does not distinguish nil from empty repeated, map and bytes fields (e.g. after marshaling): compare len(...) with 0 unless nil is meaningful here.
 _ = f3().GetMap() != nil
 `,
 		},
@@ -24,8 +24,9 @@ import (
 // applied on the file. If generated is true, then Location.IsGeneratedFile
 // will be set to true on the returned entries.
 func stats(c *cursor, f *dst.File, generated bool) []*spb.Entry {
-	// Temporarily disable stats after rewrites. Only calculate for code before our changes.
-	if c.lvl != None {
+	// Temporarily disable stats after rewrites (unless explicitly requested).
+	// Only calculate for code before our changes.
+	if c.lvl != None && !c.statsAfterRewrites {
 		return nil
 	}
 	parents := map[dst.Node]dst.Node{}
@@ -0,0 +1,143 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package analyze implements the analyze open2opaque subcommand, which exports
+// Graphviz (DOT) graphs that help with planning a migration to the Opaque API.
+package analyze
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"flag"
+	"github.com/google/subcommands"
+	"google.golang.org/open2opaque/internal/o2o/wd"
+)
+
+const (
+	graphPackages = "packages"
+	graphMessages = "messages"
+)
+
+var validGraphs = []string{graphPackages, graphMessages}
+
+// Cmd implements the analyze subcommand of the open2opaque tool.
+type Cmd struct {
+	graph        string
+	output       string
+	parallelJobs int
+}
+
+// Name implements subcommand.Command.
+func (*Cmd) Name() string { return "analyze" }
+
+// Synopsis implements subcommand.Command.
+func (*Cmd) Synopsis() string {
+	return "Export Graphviz graphs of Opaque API migration readiness."
+}
+
+// Usage implements subcommand.Command.
+func (*Cmd) Usage() string {
+	return `Usage: open2opaque analyze -graph=<` + strings.Join(validGraphs, "|") + `> [-output=<file.dot>] <target> [<target>...]
+
+The analyze subcommand writes a graph in the Graphviz DOT format:
+
+  -graph=packages: The targets are Go package import paths. The graph shows
+                   the imports between the specified packages. Nodes are
+                   colored by their migration readiness (hue) and their number
+                   of Open API uses (saturation).
+
+  -graph=messages: The targets are .proto files. The graph shows which
+                   messages contain (nest or reference) which other messages.
+                   Nodes are annotated and colored with their current Go API
+                   level.
+
+Render the output with, for example: dot -Tsvg -o graph.svg graph.dot
+
+Command-line flag documentation follows:
+`
+}
+
+// SetFlags implements subcommand.Command.
+func (cmd *Cmd) SetFlags(f *flag.FlagSet) {
+	f.StringVar(&cmd.graph, "graph", "", "which graph to export, valid values: "+strings.Join(validGraphs, ", "))
+	f.StringVar(&cmd.output, "output", "", "path of the DOT file to write; empty means standard output")
+	f.IntVar(&cmd.parallelJobs, "parallel_jobs", 20, "How many packages are analyzed in parallel (only for -graph=packages).")
+}
+
+// Execute implements subcommand.Command.
+func (cmd *Cmd) Execute(ctx context.Context, f *flag.FlagSet, _ ...any) subcommands.ExitStatus {
+	if err := cmd.analyze(ctx, f); err != nil {
+		// Use fmt.Fprintf instead of log.Exit to generate a shorter error
+		// message: users do not care about the current date/time and the fact
+		// that our code lives in analyze.go.
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return subcommands.ExitFailure
+	}
+	return subcommands.ExitSuccess
+}
+
+// Command returns an initialized Cmd for registration with the subcommands
+// package.
+func Command() *Cmd {
+	return &Cmd{}
+}
+
+func (cmd *Cmd) analyze(ctx context.Context, f *flag.FlagSet) error {
+	if _, err := wd.Adjust(); err != nil {
+		return err
+	}
+
+	targets := f.Args()
+	if len(targets) == 0 {
+		f.Usage()
+		return nil
+	}
+
+	var buf bytes.Buffer
+	switch cmd.graph {
+	case graphPackages:
+		if cmd.parallelJobs < 1 {
+			return fmt.Errorf("-parallel_jobs must be positive, got %d", cmd.parallelJobs)
+		}
+		nodes, edges, err := analyzePackages(ctx, targets, cmd.parallelJobs)
+		if err != nil {
+			return err
+		}
+		writePackageGraph(&buf, nodes, edges)
+
+	case graphMessages:
+		files, err := parseProtoFiles(targets)
+		if err != nil {
+			return err
+		}
+		writeMessageGraph(&buf, files)
+
+	default:
+		return fmt.Errorf("invalid value %q for -graph, valid values: %s", cmd.graph, strings.Join(validGraphs, ", "))
+	}
+
+	if cmd.output != "" {
+		return os.WriteFile(cmd.output, buf.Bytes(), 0644)
+	}
+	_, err := os.Stdout.Write(buf.Bytes())
+	return err
+}
+
+// dotQuote returns s as a quoted DOT identifier. Newlines are turned into
+// DOT's centered line breaks so that callers can build multi-line labels.
+func dotQuote(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, `"`, `\"`)
+	s = strings.ReplaceAll(s, "\n", `\n`)
+	return `"` + s + `"`
+}
+
+// hsv returns a DOT color in the "H S V" notation.
+func hsv(hue, saturation, value float64) string {
+	return fmt.Sprintf("%.3f %.3f %.3f", hue, saturation, value)
+}
@@ -0,0 +1,393 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analyze
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/open2opaque/internal/fix"
+	"google.golang.org/open2opaque/internal/o2o/fakeloader"
+	"google.golang.org/open2opaque/internal/o2o/loader"
+	"google.golang.org/open2opaque/internal/o2o/syncset"
+	"google.golang.org/open2opaque/internal/protoparse"
+
+	spb "google.golang.org/open2opaque/internal/dashboard"
+)
+
+func TestNodePath(t *testing.T) {
+	for _, tc := range []struct {
+		id   string
+		want string
+	}{
+		{id: "example.com/p", want: "example.com/p"},
+		{id: "example.com/p [example.com/p.test]", want: "example.com/p"},
+		{id: "example.com/p_test [example.com/p.test]", want: "example.com/p"},
+		{id: "example.com/p.test", want: "example.com/p.test"},
+	} {
+		if got := nodePath(tc.id); got != tc.want {
+			t.Errorf("nodePath(%q) = %q, want %q", tc.id, got, tc.want)
+		}
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	fieldAccess := &spb.Entry{Use: &spb.Use{Type: spb.Use_DIRECT_FIELD_ACCESS}}
+	getter := &spb.Entry{Use: &spb.Use{Type: spb.Use_METHOD_CALL}}
+	siloed := &spb.Entry{Status: &spb.Status{Type: spb.Status_FAIL}}
+
+	testCases := []struct {
+		name     string
+		res      fix.Result
+		wantUses int
+		want     readiness
+	}{
+		{
+			name: "no_open_api_uses",
+			res: fix.Result{
+				fix.None:  {{Path: "a.go", Code: "a", Stats: []*spb.Entry{getter}}},
+				fix.Green: {{Path: "a.go", Code: "a"}},
+			},
+			want: migrated,
+		},
+		{
+			name: "generated_files_are_ignored",
+			res: fix.Result{
+				fix.None:  {{Path: "a.pb.go", Code: "a", Generated: true, Stats: []*spb.Entry{fieldAccess}}},
+				fix.Green: {{Path: "a.pb.go", Code: "b", Generated: true}},
+			},
+			want: migrated,
+		},
+		{
+			name: "green",
+			res: fix.Result{
+				fix.None:   {{Path: "a.go", Code: "a", Stats: []*spb.Entry{fieldAccess, fieldAccess, getter}}},
+				fix.Green:  {{Path: "a.go", Code: "b"}},
+				fix.Yellow: {{Path: "a.go", Code: "b"}},
+				fix.Red:    {{Path: "a.go", Code: "b"}},
+			},
+			wantUses: 2,
+			want:     green,
+		},
+		{
+			name: "red",
+			res: fix.Result{
+				fix.None: {
+					{Path: "a.go", Code: "a", Stats: []*spb.Entry{fieldAccess}},
+					{Path: "b.go", Code: "b", Stats: []*spb.Entry{fieldAccess}},
+				},
+				fix.Green:  {{Path: "a.go", Code: "a2"}, {Path: "b.go", Code: "b"}},
+				fix.Yellow: {{Path: "a.go", Code: "a2"}, {Path: "b.go", Code: "b"}},
+				fix.Red:    {{Path: "a.go", Code: "a2"}, {Path: "b.go", Code: "b2"}},
+			},
+			wantUses: 2,
+			want:     red,
+		},
+		{
+			name: "open_api_uses_remain_after_rewrites",
+			res: fix.Result{
+				fix.None:   {{Path: "a.go", Code: "a", Stats: []*spb.Entry{fieldAccess, fieldAccess}}},
+				fix.Green:  {{Path: "a.go", Code: "a2", Stats: []*spb.Entry{fieldAccess}}},
+				fix.Yellow: {{Path: "a.go", Code: "a2", Stats: []*spb.Entry{fieldAccess}}},
+				fix.Red:    {{Path: "a.go", Code: "a2", Stats: []*spb.Entry{fieldAccess}}},
+			},
+			wantUses: 2,
+			want:     blocked,
+		},
+		{
+			name: "no_rewrites",
+			res: fix.Result{
+				fix.None:  {{Path: "a.go", Code: "a", Stats: []*spb.Entry{fieldAccess}}},
+				fix.Green: {{Path: "a.go", Code: "a"}},
+			},
+			wantUses: 1,
+			want:     blocked,
+		},
+		{
+			name: "missing_type_information",
+			res: fix.Result{
+				fix.None:  {{Path: "a.go", Code: "a", Stats: []*spb.Entry{siloed}}},
+				fix.Green: {{Path: "a.go", Code: "a"}},
+			},
+			want: blocked,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			gotUses, got := summarize(tc.res)
+			if gotUses != tc.wantUses || got != tc.want {
+				t.Errorf("summarize() = (%d, %v), want (%d, %v)", gotUses, got, tc.wantUses, tc.want)
+			}
+		})
+	}
+}
+
+// exportData returns the export data of the proto2 test messages of the fix
+// package and their dependencies, keyed by import path.
+func exportData(t *testing.T) map[string][]byte {
+	t.Helper()
+	goList := exec.Command("go", "list", "-e",
+		"-json=ImportPath,Export",
+		"-export=true",
+		"-deps=true",
+		"-pgo=off",
+		"google.golang.org/open2opaque/internal/fix/testdata/proto2test_go_proto")
+	goList.Stderr = os.Stderr
+	stdout, err := goList.Output()
+	if err != nil {
+		t.Fatalf("go list: %v", err)
+	}
+	export := make(map[string][]byte)
+	for dec := json.NewDecoder(bytes.NewReader(stdout)); dec.More(); {
+		var p struct{ ImportPath, Export string }
+		if err := dec.Decode(&p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Export == "" {
+			continue
+		}
+		b, err := os.ReadFile(p.Export)
+		if err != nil {
+			t.Fatal(err)
+		}
+		export[p.ImportPath] = b
+	}
+	return export
+}
+
+func TestAnalyzePackage(t *testing.T) {
+	export := exportData(t)
+
+	testCases := []struct {
+		name     string
+		body     string
+		wantUses int
+		want     readiness
+	}{
+		{
+			name: "migrated",
+			body: `_ = m.GetS()`,
+			want: migrated,
+		},
+		{
+			name:     "green",
+			body:     `m.S = proto.String("s")`,
+			wantUses: 1,
+			want:     green,
+		},
+		{
+			name:     "yellow",
+			body:     `m.B, m.S = proto.Bool(b()), proto.String(s())`,
+			wantUses: 2,
+			want:     yellow,
+		},
+		{
+			name:     "red",
+			body:     `_ = m.Is != nil`,
+			wantUses: 1,
+			want:     red,
+		},
+		{
+			name:     "oneof_field_access_remains",
+			body:     `_ = m.OneofField`,
+			wantUses: 1,
+			want:     blocked,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			const pkgPath = "example.com/p"
+			const path = pkgPath + "/p.go"
+			src := `package p
+
+import (
+	pb2 "google.golang.org/open2opaque/internal/fix/testdata/proto2test_go_proto"
+	proto "google.golang.org/protobuf/proto"
+)
+
+var _ = proto.String
+
+func b() bool   { return false }
+func s() string { return "" }
+
+func f(m *pb2.M2) {
+	` + tc.body + `
+}
+`
+			l := fakeloader.NewFakeLoader(
+				map[string][]string{pkgPath: {path}},
+				map[string]string{path: src},
+				nil,
+				func(importPath string) []byte { return export[importPath] })
+			pkg, err := loader.LoadOne(context.Background(), l, &loader.Target{ID: pkgPath})
+			if err != nil {
+				t.Fatal(err)
+			}
+			gotUses, got, err := analyzePackage(l, pkg, syncset.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gotUses != tc.wantUses || got != tc.want {
+				t.Errorf("analyzePackage() = (%d, %v), want (%d, %v)", gotUses, got, tc.wantUses, tc.want)
+			}
+		})
+	}
+}
+
+// batchLoader emulates the BlazeLoader, which fails all targets of a batch if
+// any package in it has errors.
+type batchLoader struct {
+	loader.Loader
+}
+
+func (l batchLoader) LoadPackages(ctx context.Context, targets []*loader.Target, res chan loader.LoadResult) {
+	results := loadAll(ctx, l.Loader, targets)
+	for _, r := range results {
+		if r.Err != nil {
+			for _, t := range targets {
+				res <- loader.LoadResult{Target: t, Err: r.Err}
+			}
+			return
+		}
+	}
+	for _, r := range results {
+		res <- r
+	}
+}
+
+func TestLoadBatch(t *testing.T) {
+	const (
+		good   = "example.com/good"
+		broken = "example.com/broken"
+	)
+	l := batchLoader{fakeloader.NewFakeLoader(
+		map[string][]string{
+			good:   {good + "/good.go"},
+			broken: {broken + "/broken.go"},
+		},
+		map[string]string{
+			good + "/good.go":     "package good\n\nvar x int = 1\n",
+			broken + "/broken.go": "package broken\n\nvar x int = \"s\"\n",
+		},
+		nil,
+		func(string) []byte { return nil })}
+
+	results := loadBatch(context.Background(), l, []*loader.Target{{ID: good}, {ID: broken}})
+	if len(results) != 2 {
+		t.Fatalf("loadBatch() returned %d results, want 2", len(results))
+	}
+	for _, res := range results {
+		switch res.Target.ID {
+		case good:
+			if res.Err != nil {
+				t.Errorf("loadBatch(): %s: unexpected error: %v", good, res.Err)
+			}
+		case broken:
+			if res.Err == nil || !strings.Contains(res.Err.Error(), "broken.go") {
+				t.Errorf("loadBatch(): %s: got error %v, want the type error in broken.go", broken, res.Err)
+			}
+		default:
+			t.Errorf("loadBatch(): unexpected target %s", res.Target.ID)
+		}
+	}
+}
+
+func TestWritePackageGraph(t *testing.T) {
+	nodes := []*pkgNode{
+		{path: "example.com/a", openUses: 4, readiness: yellow},
+		{path: "example.com/b", readiness: migrated},
+		{path: "example.com/c", readiness: blocked, errs: []string{`can't load "c"`}},
+	}
+	edges := map[string][]string{
+		"example.com/a": {"example.com/c", "example.com/b"},
+	}
+	var buf bytes.Buffer
+	writePackageGraph(&buf, nodes, edges)
+	want := `digraph packages {
+	rankdir=LR;
+	node [shape=box, style=filled];
+	"example.com/a" [label="example.com/a\nOpen API uses: 4\nreadiness: yellow", fillcolor="0.150 1.000 1.000"];
+	"example.com/b" [label="example.com/b\nOpen API uses: 0\nreadiness: migrated", fillcolor="0.550 0.150 1.000"];
+	"example.com/c" [label="example.com/c\nOpen API uses: 0\nreadiness: blocked", fillcolor="0.000 0.000 0.750", tooltip="can't load \"c\""];
+	"example.com/a" -> "example.com/b";
+	"example.com/a" -> "example.com/c";
+}
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("writePackageGraph: diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestWriteMessageGraph(t *testing.T) {
+	const protoIn = `
+edition = "2023";
+
+package pkg;
+
+import "google/protobuf/go_features.proto";
+
+option features.(pb.go).api_level = API_OPEN;
+
+message A {
+  option features.(pb.go).api_level = API_HYBRID;
+
+  message B {
+    enum C {
+      C_UNSPECIFIED = 0;
+    }
+    C c = 1;
+  }
+
+  B b = 1;
+  repeated B bs = 2;
+  map<string, D> ds = 3;
+  .pkg.A self = 4;
+}
+
+message C {}
+
+message D {
+  int32 i = 1;
+  A.B b = 2;
+}
+`
+	parser := protoparse.NewParserWithAccessor(func(string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(protoIn)), nil
+	})
+	fopt, err := parser.ParseFile("pkg.proto", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	writeMessageGraph(&buf, []*protoparse.FileOpt{fopt})
+	want := `digraph messages {
+	rankdir=LR;
+	node [shape=box, style=filled];
+	subgraph cluster_0 {
+		label="pkg.proto\npackage pkg\nfile: API_OPEN (line 8)";
+		"pkg.A" [label="A\nAPI_HYBRID (line 11)", fillcolor="0.150 0.400 1.000", style="filled,bold"];
+		"pkg.A.B" [label="A.B\nAPI_HYBRID", fillcolor="0.150 0.400 1.000", style="filled"];
+		"pkg.C" [label="C\nAPI_OPEN", fillcolor="0.000 0.400 1.000", style="filled"];
+		"pkg.D" [label="D\nAPI_OPEN", fillcolor="0.000 0.400 1.000", style="filled"];
+	}
+	"pkg.A" -> "pkg.A.B" [style=dashed];
+	"pkg.A" -> "pkg.A.B" [label="b, bs"];
+	"pkg.A" -> "pkg.D" [label="ds"];
+	"pkg.A" -> "pkg.A" [label="self"];
+	"pkg.D" -> "pkg.A.B" [label="b"];
+}
+`
+	if diff := cmp.Diff(want, buf.String()); diff != "" {
+		t.Errorf("writeMessageGraph: diff (-want +got):\n%s", diff)
+	}
+}
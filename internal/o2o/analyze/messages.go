@@ -0,0 +1,266 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analyze
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"google.golang.org/open2opaque/internal/protoparse"
+	descpb "google.golang.org/protobuf/types/descriptorpb"
+	gofeaturespb "google.golang.org/protobuf/types/gofeaturespb"
+)
+
+// parseProtoFiles parses the specified .proto files including the API level
+// options of their messages.
+func parseProtoFiles(names []string) ([]*protoparse.FileOpt, error) {
+	parser := protoparse.NewParser()
+	var files []*protoparse.FileOpt
+	for _, name := range names {
+		fopt, err := parser.ParseFile(name, false)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, fopt)
+	}
+	return files, nil
+}
+
+// msgNode is a node in the message containment graph.
+type msgNode struct {
+	fullName string
+	desc     *descpb.DescriptorProto
+	opt      *protoparse.MessageOpt
+}
+
+// msgEdge is an edge in the message containment graph. If fields is empty,
+// the edge points from a message to a message nested in its body. Otherwise,
+// fields lists the fields of message from that are of message type to (or a
+// repeated or map thereof).
+type msgEdge struct {
+	from, to string
+	fields   []string
+}
+
+// msgGraph is the message containment graph of a set of .proto files.
+type msgGraph struct {
+	files []*protoparse.FileOpt
+	// nodes of each file, in the same order as files.
+	nodes [][]*msgNode
+	// byName maps the fully-qualified names of all messages to their nodes.
+	byName map[string]*msgNode
+	// mapEntries maps the fully-qualified names of map-entry messages to their
+	// value fields. Map entries are generated by protoc and have no node.
+	mapEntries map[string]*descpb.FieldDescriptorProto
+	// enums contains the fully-qualified names of all enums. They are needed
+	// to resolve type names, e.g. a nested enum shadows an outer message.
+	enums map[string]bool
+	edges []*msgEdge
+}
+
+func newMsgGraph(files []*protoparse.FileOpt) *msgGraph {
+	g := &msgGraph{
+		files:      files,
+		nodes:      make([][]*msgNode, len(files)),
+		byName:     make(map[string]*msgNode),
+		mapEntries: make(map[string]*descpb.FieldDescriptorProto),
+		enums:      make(map[string]bool),
+	}
+	for i, f := range files {
+		opts := make(map[string]*protoparse.MessageOpt)
+		for _, m := range f.MessageOpts {
+			traverseMsgOpts(m, func(m *protoparse.MessageOpt) {
+				opts[m.Message] = m
+			})
+		}
+		var add func(d *descpb.DescriptorProto, localPrefix string)
+		add = func(d *descpb.DescriptorProto, localPrefix string) {
+			local := localPrefix + d.GetName()
+			full := qualify(f.Package, local)
+			if d.GetOptions().GetMapEntry() {
+				for _, fd := range d.GetField() {
+					// Map entries store the key in field 1 and the value in field 2.
+					const valueFieldNumber = 2
+					if fd.GetNumber() == valueFieldNumber {
+						g.mapEntries[full] = fd
+					}
+				}
+				return
+			}
+			n := &msgNode{
+				fullName: full,
+				desc:     d,
+				opt:      opts[local],
+			}
+			g.nodes[i] = append(g.nodes[i], n)
+			g.byName[full] = n
+			for _, e := range d.GetEnumType() {
+				g.enums[full+"."+e.GetName()] = true
+			}
+			for _, nested := range d.GetNestedType() {
+				add(nested, local+".")
+			}
+		}
+		for _, e := range f.Desc.GetEnumType() {
+			g.enums[qualify(f.Package, e.GetName())] = true
+		}
+		for _, d := range f.Desc.GetMessageType() {
+			add(d, "")
+		}
+	}
+
+	edgeIdx := make(map[[2]string]*msgEdge)
+	for _, nodes := range g.nodes {
+		for _, n := range nodes {
+			for _, nested := range n.desc.GetNestedType() {
+				if nested.GetOptions().GetMapEntry() {
+					continue
+				}
+				g.edges = append(g.edges, &msgEdge{from: n.fullName, to: n.fullName + "." + nested.GetName()})
+			}
+			for _, fd := range n.desc.GetField() {
+				to, ok := g.resolveMessage(n.fullName, fd.GetTypeName())
+				if !ok {
+					continue
+				}
+				key := [2]string{n.fullName, to}
+				e, ok := edgeIdx[key]
+				if !ok {
+					e = &msgEdge{from: n.fullName, to: to}
+					edgeIdx[key] = e
+					g.edges = append(g.edges, e)
+				}
+				e.fields = append(e.fields, fd.GetName())
+			}
+		}
+	}
+	return g
+}
+
+func traverseMsgOpts(opt *protoparse.MessageOpt, f func(*protoparse.MessageOpt)) {
+	f(opt)
+	for _, c := range opt.Children {
+		traverseMsgOpts(c, f)
+	}
+}
+
+func qualify(pkg, name string) string {
+	if pkg == "" {
+		return name
+	}
+	return pkg + "." + name
+}
+
+// resolveMessage returns the fully-qualified name of the message that the
+// field type name refers to when used in the body of the message scope. For
+// map fields, it returns the message type of the map values. It returns false
+// if typeName does not refer to any message of the graph, e.g. because it
+// refers to an enum, a scalar type or a message in a file that was not parsed.
+//
+// The descriptors are not linked, so the type name is resolved following the
+// protobuf scoping rules: starting at the innermost scope, going outwards.
+func (g *msgGraph) resolveMessage(scope, typeName string) (string, bool) {
+	if typeName == "" {
+		return "", false
+	}
+	var full string
+	if strings.HasPrefix(typeName, ".") {
+		full = typeName[1:]
+	} else {
+		for {
+			candidate := qualify(scope, typeName)
+			if g.enums[candidate] {
+				return "", false
+			}
+			if _, ok := g.byName[candidate]; ok {
+				full = candidate
+				break
+			}
+			if _, ok := g.mapEntries[candidate]; ok {
+				full = candidate
+				break
+			}
+			if scope == "" {
+				return "", false
+			}
+			idx := strings.LastIndex(scope, ".")
+			if idx < 0 {
+				scope = ""
+			} else {
+				scope = scope[:idx]
+			}
+		}
+	}
+	if value, ok := g.mapEntries[full]; ok {
+		return g.resolveMessage(full, value.GetTypeName())
+	}
+	_, ok := g.byName[full]
+	return full, ok
+}
+
+// apiLevelFillColor encodes the Go API level as hue: red for the Open API,
+// yellow for the Hybrid API and green for the Opaque API.
+func apiLevelFillColor(api gofeaturespb.GoFeatures_APILevel) string {
+	switch api {
+	case gofeaturespb.GoFeatures_API_OPEN:
+		return hsv(0, 0.4, 1)
+	case gofeaturespb.GoFeatures_API_HYBRID:
+		return hsv(0.15, 0.4, 1)
+	case gofeaturespb.GoFeatures_API_OPAQUE:
+		return hsv(0.33, 0.4, 1)
+	}
+	return hsv(0, 0, 1)
+}
+
+// apiLevelLabel describes the API level and where it is defined. The line
+// numbers of explicit definitions are 1-based.
+func apiLevelLabel(api gofeaturespb.GoFeatures_APILevel, info *protoparse.APIInfo) string {
+	if info == nil {
+		return api.String()
+	}
+	return fmt.Sprintf("%s (line %d)", api, info.TextRange.BeginLine+1)
+}
+
+// writeMessageGraph writes the message containment graph of files in the DOT
+// format to buf. Messages are grouped by file and nested messages are
+// connected to their parent by dashed edges. Explicit API level definitions
+// are drawn with a bold border.
+func writeMessageGraph(buf *bytes.Buffer, files []*protoparse.FileOpt) {
+	g := newMsgGraph(files)
+
+	fmt.Fprintf(buf, "digraph messages {\n")
+	fmt.Fprintf(buf, "\trankdir=LR;\n")
+	fmt.Fprintf(buf, "\tnode [shape=box, style=filled];\n")
+	for i, f := range g.files {
+		fmt.Fprintf(buf, "\tsubgraph cluster_%d {\n", i)
+		label := fmt.Sprintf("%s\npackage %s\nfile: %s", f.File, f.Package, apiLevelLabel(f.GoAPI, f.APIInfo))
+		fmt.Fprintf(buf, "\t\tlabel=%s;\n", dotQuote(label))
+		for _, n := range g.nodes[i] {
+			api := f.GoAPI
+			var info *protoparse.APIInfo
+			style := "filled"
+			if n.opt != nil {
+				api = n.opt.GoAPI
+				info = n.opt.APIInfo
+				if n.opt.IsExplicit {
+					style = "filled,bold"
+				}
+			}
+			label := strings.TrimPrefix(n.fullName, f.Package+".") + "\n" + apiLevelLabel(api, info)
+			fmt.Fprintf(buf, "\t\t%s [label=%s, fillcolor=%s, style=%s];\n",
+				dotQuote(n.fullName), dotQuote(label), dotQuote(apiLevelFillColor(api)), dotQuote(style))
+		}
+		fmt.Fprintf(buf, "\t}\n")
+	}
+	for _, e := range g.edges {
+		if len(e.fields) == 0 {
+			fmt.Fprintf(buf, "\t%s -> %s [style=dashed];\n", dotQuote(e.from), dotQuote(e.to))
+			continue
+		}
+		fmt.Fprintf(buf, "\t%s -> %s [label=%s];\n", dotQuote(e.from), dotQuote(e.to), dotQuote(strings.Join(e.fields, ", ")))
+	}
+	fmt.Fprintf(buf, "}\n")
+}
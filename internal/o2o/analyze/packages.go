@@ -0,0 +1,366 @@
+// Copyright 2024 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package analyze
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/tools/go/packages"
+	"google.golang.org/open2opaque/internal/fix"
+	"google.golang.org/open2opaque/internal/o2o/loader"
+	"google.golang.org/open2opaque/internal/o2o/syncset"
+
+	spb "google.golang.org/open2opaque/internal/dashboard"
+)
+
+// readiness describes how much work is left to migrate a Go package to the
+// Opaque API. Larger values mean more work.
+type readiness int
+
+const (
+	// migrated packages do not use the Open API.
+	migrated readiness = iota
+	// green packages only need green rewrites.
+	green
+	// yellow packages need yellow rewrites, which should be reviewed.
+	yellow
+	// red packages need red rewrites, which can change behavior.
+	red
+	// blocked packages could not be analyzed, or use the Open API in ways
+	// that open2opaque does not rewrite at any level.
+	blocked
+)
+
+func (r readiness) String() string {
+	switch r {
+	case migrated:
+		return "migrated"
+	case green:
+		return "green"
+	case yellow:
+		return "yellow"
+	case red:
+		return "red"
+	case blocked:
+		return "blocked"
+	}
+	return fmt.Sprintf("readiness(%d)", int(r))
+}
+
+// levelReadiness maps the rewrite levels, in increasing order, to the
+// readiness of a package that needs rewrites up to that level.
+var levelReadiness = []struct {
+	lvl fix.Level
+	r   readiness
+}{
+	{fix.Green, green},
+	{fix.Yellow, yellow},
+	{fix.Red, red},
+}
+
+// pkgNode is a node in the package import graph.
+type pkgNode struct {
+	path      string
+	openUses  int
+	readiness readiness
+	errs      []string
+}
+
+// add merges the analysis of one variant of the package (e.g. the package
+// with its in-package tests) into n.
+func (n *pkgNode) add(openUses int, r readiness, err error) {
+	n.openUses += openUses
+	if r > n.readiness {
+		n.readiness = r
+	}
+	if err != nil {
+		n.errs = append(n.errs, err.Error())
+	}
+}
+
+// fillColor encodes the readiness as hue and the number of Open API uses,
+// relative to the package with the most uses, as saturation.
+func (n *pkgNode) fillColor(maxUses int) string {
+	if n.readiness == blocked {
+		return hsv(0, 0, 0.75)
+	}
+	saturation := 0.15
+	if maxUses > 0 {
+		saturation += 0.85 * float64(n.openUses) / float64(maxUses)
+	}
+	var hue float64
+	switch n.readiness {
+	case migrated:
+		hue = 0.55
+	case green:
+		hue = 0.33
+	case yellow:
+		hue = 0.15
+	case red:
+		hue = 0
+	}
+	return hsv(hue, saturation, 1)
+}
+
+// nodePath returns the import path of the graph node that the go/packages ID
+// belongs to. Test variants ("p [p.test]") and external test packages
+// ("p_test [p.test]") are attributed to the package under test.
+func nodePath(id string) string {
+	path, variant, _ := strings.Cut(id, " ")
+	if variant != "" {
+		path = strings.TrimSuffix(path, "_test")
+	}
+	return path
+}
+
+// analyzePackages loads the Go packages matching targets and returns the
+// analyzed packages (sorted by import path) along with the imports between
+// them.
+func analyzePackages(ctx context.Context, targets []string, parallelJobs int) ([]*pkgNode, map[string][]string, error) {
+	fmt.Fprintf(os.Stderr, "Resolving Go package names...\n")
+	cfg := &packages.Config{
+		Context: ctx,
+		Mode:    packages.NeedName | packages.NeedImports,
+	}
+	loaded, err := packages.Load(cfg, targets...)
+	if err != nil {
+		return nil, nil, fmt.Errorf("can't read the package list: %v", err)
+	}
+
+	nodes := make(map[string]*pkgNode)
+	var loaderTargets []*loader.Target
+	for _, p := range loaded {
+		n := &pkgNode{path: p.PkgPath}
+		nodes[p.PkgPath] = n
+		if len(p.Errors) > 0 {
+			// The package list already reports these errors, so do not load
+			// broken packages again.
+			for _, err := range p.Errors {
+				n.add(0, blocked, err)
+			}
+			continue
+		}
+		loaderTargets = append(loaderTargets, &loader.Target{ID: p.ID})
+	}
+	edges := make(map[string][]string)
+	for _, p := range loaded {
+		for _, imp := range p.Imports {
+			if _, ok := nodes[imp.PkgPath]; ok {
+				edges[p.PkgPath] = append(edges[p.PkgPath], imp.PkgPath)
+			}
+		}
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return nil, nil, err
+	}
+	l, err := loader.NewBlazeLoader(ctx, &loader.Config{}, wd)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer l.Close(ctx)
+
+	fmt.Fprintf(os.Stderr, "Analyzing %d packages (in batches of up to %d)...\n", len(loaderTargets), parallelJobs)
+	processed := syncset.New() // avoid counting files of test variants twice
+	var mu sync.Mutex
+	for idx := 0; idx < len(loaderTargets); idx += parallelJobs {
+		end := min(idx+parallelJobs, len(loaderTargets))
+		var wg sync.WaitGroup
+		for _, res := range loadBatch(ctx, l, loaderTargets[idx:end]) {
+			n := nodes[nodePath(res.Target.ID)]
+			if n == nil {
+				// For example the synthesized main package of a test ("p.test").
+				continue
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var (
+					uses int
+					r    = blocked
+					err  = res.Err
+				)
+				if err == nil {
+					uses, r, err = analyzePackage(l, res.Package, processed)
+				}
+				mu.Lock()
+				defer mu.Unlock()
+				n.add(uses, r, err)
+			}()
+		}
+		wg.Wait()
+	}
+
+	sorted := make([]*pkgNode, 0, len(nodes))
+	for _, n := range nodes {
+		sorted = append(sorted, n)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].path < sorted[j].path })
+	return sorted, edges, nil
+}
+
+// loadBatch loads targets with l. The loader fails the whole batch if any
+// package in it has errors (e.g. type errors, which the package list does not
+// report), so loadBatch reloads the failed targets one at a time to attribute
+// each error to its own package.
+func loadBatch(ctx context.Context, l loader.Loader, targets []*loader.Target) []loader.LoadResult {
+	results := loadAll(ctx, l, targets)
+	if len(targets) < 2 {
+		return results
+	}
+	var out []loader.LoadResult
+	for _, res := range results {
+		if res.Err == nil || !slices.Contains(targets, res.Target) {
+			out = append(out, res)
+			continue
+		}
+		out = append(out, loadAll(ctx, l, []*loader.Target{res.Target})...)
+	}
+	return out
+}
+
+// loadAll loads targets with l and returns all results, including those of
+// test variants.
+func loadAll(ctx context.Context, l loader.Loader, targets []*loader.Target) []loader.LoadResult {
+	ch := make(chan loader.LoadResult)
+	go func() {
+		l.LoadPackages(ctx, targets, ch)
+		close(ch)
+	}()
+	var results []loader.LoadResult
+	for res := range ch {
+		results = append(results, res)
+	}
+	return results
+}
+
+// analyzePackage runs all rewrite levels on pkg without writing any files and
+// returns the number of Open API uses in the package and its readiness.
+func analyzePackage(l loader.Loader, pkg *loader.Package, processed *syncset.Set) (openUses int, _ readiness, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %s", r)
+		}
+	}()
+
+	cpkg := fix.ConfiguredPackage{
+		Loader:         l,
+		Pkg:            pkg,
+		Levels:         []fix.Level{fix.Green, fix.Yellow, fix.Red},
+		ProcessedFiles: processed,
+		UseBuilders:    fix.BuildersEverywhere,
+		// summarize needs the Open API uses that remain after the rewrites.
+		StatsAfterRewrites: true,
+	}
+	res, err := cpkg.Fix()
+	if err != nil {
+		return 0, blocked, err
+	}
+	openUses, r := summarize(res)
+	return openUses, r, nil
+}
+
+// summarize counts the Open API uses in the non-generated files of res and
+// determines the readiness: the highest rewrite level that changes any code,
+// or blocked if Open API uses remain after the rewrites of all levels.
+func summarize(res fix.Result) (openUses int, _ readiness) {
+	code := make(map[string]string)
+	missingTypes := false
+	for _, f := range res[fix.None] {
+		code[f.Path] = f.Code
+		if f.Generated {
+			continue
+		}
+		for _, e := range f.Stats {
+			if e.GetStatus().GetType() == spb.Status_FAIL {
+				missingTypes = true
+			}
+			if isOpenAPIUse(e) {
+				openUses++
+			}
+		}
+	}
+	if missingTypes {
+		return openUses, blocked
+	}
+	if openUses == 0 {
+		return 0, migrated
+	}
+	// The stats of the rewritten files describe the code after the rewrites.
+	for _, f := range res[fix.Red] {
+		if f.Generated {
+			continue
+		}
+		for _, e := range f.Stats {
+			if isOpenAPIUse(e) {
+				return openUses, blocked
+			}
+		}
+	}
+
+	r := blocked
+	for _, lr := range levelReadiness {
+		for _, f := range res[lr.lvl] {
+			if f.Generated {
+				continue
+			}
+			if f.Code != code[f.Path] {
+				r = lr.r
+			}
+			code[f.Path] = f.Code
+		}
+	}
+	return openUses, r
+}
+
+// isOpenAPIUse reports whether the stats entry e describes a use of a proto
+// message that does not compile with the Opaque API.
+func isOpenAPIUse(e *spb.Entry) bool {
+	switch use := e.GetUse(); use.GetType() {
+	case spb.Use_DIRECT_FIELD_ACCESS, spb.Use_INTERNAL_FIELD_ACCESS, spb.Use_SHALLOW_COPY:
+		return true
+	case spb.Use_CONSTRUCTOR:
+		return use.GetConstructor().GetType() == spb.Constructor_NONEMPTY_LITERAL
+	case spb.Use_METHOD_CALL:
+		return use.GetMethodCall().GetType() == spb.MethodCall_GET_ONEOF
+	}
+	return false
+}
+
+// writePackageGraph writes the package import graph in the DOT format to buf.
+func writePackageGraph(buf *bytes.Buffer, nodes []*pkgNode, edges map[string][]string) {
+	maxUses := 0
+	for _, n := range nodes {
+		maxUses = max(maxUses, n.openUses)
+	}
+
+	fmt.Fprintf(buf, "digraph packages {\n")
+	fmt.Fprintf(buf, "\trankdir=LR;\n")
+	fmt.Fprintf(buf, "\tnode [shape=box, style=filled];\n")
+	for _, n := range nodes {
+		label := fmt.Sprintf("%s\nOpen API uses: %d\nreadiness: %s", n.path, n.openUses, n.readiness)
+		attrs := fmt.Sprintf("label=%s, fillcolor=%s", dotQuote(label), dotQuote(n.fillColor(maxUses)))
+		if len(n.errs) > 0 {
+			attrs += ", tooltip=" + dotQuote(strings.Join(n.errs, "\n"))
+		}
+		fmt.Fprintf(buf, "\t%s [%s];\n", dotQuote(n.path), attrs)
+	}
+	for _, n := range nodes {
+		imports := edges[n.path]
+		sort.Strings(imports)
+		for _, imp := range imports {
+			fmt.Fprintf(buf, "\t%s -> %s;\n", dotQuote(n.path), dotQuote(imp))
+		}
+	}
+	fmt.Fprintf(buf, "}\n")
+}
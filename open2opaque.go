@@ -18,6 +18,7 @@ import (
 
 	"flag"
 	"github.com/google/subcommands"
+	"google.golang.org/open2opaque/internal/o2o/analyze"
 	"google.golang.org/open2opaque/internal/o2o/rewrite"
 	"google.golang.org/open2opaque/internal/o2o/setapi"
 	"google.golang.org/open2opaque/internal/o2o/version"
@@ -58,6 +59,9 @@ Report issues at https://github.com/golang/open2opaque/issues
 	const groupRewrite = "automatically rewriting Go code"
 	commander.Register(rewrite.Command(), groupRewrite)
 
+	const groupAnalyze = "planning the migration"
+	commander.Register(analyze.Command(), groupAnalyze)
+
 	const groupFlag = "managing the API level"
 	commander.Register(setapi.Command(), groupFlag)
 